@@ -0,0 +1,118 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2019-06-01/storage"
+	"k8s.io/legacy-cloud-providers/azure"
+)
+
+// accountKeyTTL is how long a managed-identity-fetched account key is cached
+// before being re-fetched from the storage account management API.
+const accountKeyTTL = 30 * time.Minute
+
+// AccountKeyGetter fetches the primary key of a storage account through the Azure
+// management plane, authenticating with the driver's AAD token rather than a
+// pre-shared key from a Kubernetes secret. It is an interface so tests can
+// substitute a fake implementation, the same way TestGetFileSvcClient fakes the
+// account name/key pair directly.
+type AccountKeyGetter interface {
+	GetAccountKey(ctx context.Context, subscriptionID, resourceGroup, accountName string) (string, error)
+}
+
+// cloudAccountKeyGetter fetches the account key through the storage account
+// management API, using the AAD token source already carried by azure.Cloud.
+type cloudAccountKeyGetter struct {
+	cloud *azure.Cloud
+}
+
+// GetAccountKey lists the storage account's keys through the management plane
+// and returns the first key with full permissions. subscriptionID is not used
+// directly here: g.cloud.StorageAccountClient is already scoped to a single
+// subscription, but it's kept on the interface since callers (and the cache)
+// key their lookups by it.
+func (g *cloudAccountKeyGetter) GetAccountKey(ctx context.Context, subscriptionID, resourceGroup, accountName string) (string, error) {
+	result, err := g.cloud.StorageAccountClient.ListKeys(ctx, resourceGroup, accountName)
+	if err != nil {
+		return "", fmt.Errorf("failed to list keys for account %s: %v", accountName, err)
+	}
+	if result.Keys == nil || len(*result.Keys) == 0 {
+		return "", fmt.Errorf("no keys returned for account %s", accountName)
+	}
+	for _, key := range *result.Keys {
+		if key.Permissions == storage.Full && key.Value != nil {
+			return *key.Value, nil
+		}
+	}
+	if (*result.Keys)[0].Value == nil {
+		return "", fmt.Errorf("account %s key value is empty", accountName)
+	}
+	return *(*result.Keys)[0].Value, nil
+}
+
+type accountKeyCacheEntry struct {
+	key       string
+	expiresAt time.Time
+}
+
+// accountKeyCache caches account keys fetched via an AccountKeyGetter, keyed by
+// (subscription, resource group, account), so CreateVolume/DeleteVolume/CreateSnapshot/
+// DeleteSnapshot/NodeStageVolume don't each re-hit the management API.
+type accountKeyCache struct {
+	mu      sync.Mutex
+	entries map[string]accountKeyCacheEntry
+	getter  AccountKeyGetter
+	ttl     time.Duration
+	now     func() time.Time
+}
+
+func newAccountKeyCache(getter AccountKeyGetter) *accountKeyCache {
+	return &accountKeyCache{
+		entries: make(map[string]accountKeyCacheEntry),
+		getter:  getter,
+		ttl:     accountKeyTTL,
+		now:     time.Now,
+	}
+}
+
+func (c *accountKeyCache) get(ctx context.Context, subscriptionID, resourceGroup, accountName string) (string, error) {
+	cacheKey := strings.Join([]string{subscriptionID, resourceGroup, accountName}, separator)
+
+	c.mu.Lock()
+	if entry, ok := c.entries[cacheKey]; ok && c.now().Before(entry.expiresAt) {
+		c.mu.Unlock()
+		return entry.key, nil
+	}
+	c.mu.Unlock()
+
+	key, err := c.getter.GetAccountKey(ctx, subscriptionID, resourceGroup, accountName)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	c.entries[cacheKey] = accountKeyCacheEntry{key: key, expiresAt: c.now().Add(c.ttl)}
+	c.mu.Unlock()
+
+	return key, nil
+}
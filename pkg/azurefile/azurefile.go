@@ -0,0 +1,376 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-storage-file-go/azfile"
+	"k8s.io/legacy-cloud-providers/azure"
+	"k8s.io/utils/mount"
+)
+
+const (
+	// DriverName is the name of the CSI driver
+	DriverName = "file.csi.azure.com"
+
+	fileMode        = "file_mode"
+	dirMode         = "dir_mode"
+	vers            = "vers"
+	defaultFileMode = "0777"
+	defaultDirMode  = "0777"
+	defaultVers     = "3.0"
+
+	// protocol is the storage class parameter that selects the share protocol (smb or nfs)
+	protocolField = "protocol"
+	smb           = "smb"
+	nfs           = "nfs"
+
+	// NFS mount option keys / defaults
+	nconnect        = "nconnect"
+	defaultNconnect = "4"
+	proto           = "proto"
+	defaultProto    = "tcp"
+
+	// authType is the storage class parameter that selects how the node plugin
+	// authenticates SMB mounts: accountkey (default) or kerberos
+	authTypeField      = "authtype"
+	authTypeAccountKey = "accountkey"
+	authTypeKerberos   = "kerberos"
+
+	// Kerberos/AAD-DS CIFS mount option keys
+	secOption       = "sec"
+	krb5iValue      = "krb5i"
+	cruidOption     = "cruid"
+	serverinoOption = "serverino"
+	nopermOption    = "noperm"
+
+	separator = "#"
+)
+
+// Driver implements the CSI Driver interface for Azure File
+type Driver struct {
+	Name    string
+	Version string
+	NodeID  string
+
+	// UseManagedIdentity, when set, makes the controller plugin fetch storage
+	// account keys on demand through the AAD token carried by cloud instead of
+	// requiring a nodeStageSecretRef in the storage class.
+	UseManagedIdentity bool
+
+	cloud            *azure.Cloud
+	mounter          *mount.SafeFormatAndMount
+	accountKeyGetter AccountKeyGetter
+	accountKeys      *accountKeyCache
+}
+
+// NewDriver creates a new Driver
+func NewDriver(nodeID string) *Driver {
+	driver := &Driver{
+		Name:   DriverName,
+		NodeID: nodeID,
+	}
+	return driver
+}
+
+// appendDefaultMountOptions append default mount options, only used for SMB/CIFS protocol.
+// NodeStageVolume now validates CIFS options through ParseMountOptions/CIFSMountOptions
+// instead; this is kept as a thin, permissive shim for the Kerberos mount option path.
+func appendDefaultMountOptions(mountOptions []string) []string {
+	fileModeFlag := false
+	dirModeFlag := false
+	versFlag := false
+
+	for _, mountOption := range mountOptions {
+		if strings.HasPrefix(mountOption, fileMode) {
+			fileModeFlag = true
+		}
+		if strings.HasPrefix(mountOption, dirMode) {
+			dirModeFlag = true
+		}
+		if strings.HasPrefix(mountOption, vers) {
+			versFlag = true
+		}
+	}
+
+	if !fileModeFlag {
+		mountOptions = append(mountOptions, fmt.Sprintf("%s=%s", fileMode, defaultFileMode))
+	}
+
+	if !dirModeFlag {
+		mountOptions = append(mountOptions, fmt.Sprintf("%s=%s", dirMode, defaultDirMode))
+	}
+
+	if !versFlag {
+		mountOptions = append(mountOptions, fmt.Sprintf("%s=%s", vers, defaultVers))
+	}
+
+	return mountOptions
+}
+
+// appendDefaultNFSMountOptions appends default mount options used for the NFS 4.1 protocol
+func appendDefaultNFSMountOptions(mountOptions []string) []string {
+	nconnectFlag := false
+	protoFlag := false
+	hardFlag := false
+
+	for _, mountOption := range mountOptions {
+		if strings.HasPrefix(mountOption, nconnect) {
+			nconnectFlag = true
+		}
+		if strings.HasPrefix(mountOption, proto) {
+			protoFlag = true
+		}
+		if mountOption == "hard" || mountOption == "soft" {
+			hardFlag = true
+		}
+	}
+
+	if !nconnectFlag {
+		mountOptions = append(mountOptions, fmt.Sprintf("%s=%s", nconnect, defaultNconnect))
+	}
+
+	if !protoFlag {
+		mountOptions = append(mountOptions, fmt.Sprintf("%s=%s", proto, defaultProto))
+	}
+
+	if !hardFlag {
+		mountOptions = append(mountOptions, "hard")
+	}
+
+	return mountOptions
+}
+
+// appendKerberosMountOptions appends the CIFS options required for Kerberos/AAD-DS
+// identity-based authentication on top of the regular default mount options. It relies
+// on a kinit-acquired ticket cache already present on the node for cruid to resolve.
+func appendKerberosMountOptions(mountOptions []string, cruid string, serverino, noperm bool) []string {
+	mountOptions = appendDefaultMountOptions(mountOptions)
+
+	secFlag := false
+	cruidFlag := false
+	for _, mountOption := range mountOptions {
+		if strings.HasPrefix(mountOption, secOption) {
+			secFlag = true
+		}
+		if strings.HasPrefix(mountOption, cruidOption) {
+			cruidFlag = true
+		}
+	}
+
+	if !secFlag {
+		mountOptions = append(mountOptions, fmt.Sprintf("%s=%s", secOption, krb5iValue))
+	}
+	if !cruidFlag && cruid != "" {
+		mountOptions = append(mountOptions, fmt.Sprintf("%s=%s", cruidOption, cruid))
+	}
+	if serverino {
+		mountOptions = append(mountOptions, serverinoOption)
+	}
+	if noperm {
+		mountOptions = append(mountOptions, nopermOption)
+	}
+
+	return mountOptions
+}
+
+// isKerberosAuth returns true when the storage class/volume context requests
+// Kerberos/AAD-DS identity-based authentication rather than the account-key default
+func isKerberosAuth(authType string) bool {
+	return strings.EqualFold(authType, authTypeKerberos)
+}
+
+// getFileShareInfo parses a volume id (legacy "#"-delimited or v2 structured) and
+// returns its resource group, account, file share, disk name and protocol
+// components. It is a thin shim over ParseVolumeID kept for callers that only
+// need these fields; protocol is only ever populated for v2 handles, since the
+// legacy format has no slot for it.
+func getFileShareInfo(id string) (string, string, string, string, string, error) {
+	v, err := ParseVolumeID(id)
+	if err != nil {
+		return "", "", "", "", "", err
+	}
+	return v.ResourceGroup, v.Account, v.Share, v.DiskName, v.Protocol, nil
+}
+
+// getSnapshot parses a snapshot volume id and returns its snapshot time. It is a thin
+// shim over ParseVolumeID that preserves the legacy requirement that a snapshot id
+// carry a disk name and snapshot time segment, unlike a plain volume id.
+func getSnapshot(id string) (string, error) {
+	if !strings.HasPrefix(id, volumeIDV2Prefix) {
+		segments := strings.Split(id, separator)
+		if len(segments) < 5 {
+			return "", fmt.Errorf("error parsing volume id: %q, should at least contain four %s", id, separator)
+		}
+	}
+	v, err := ParseVolumeID(id)
+	if err != nil {
+		return "", err
+	}
+	if v.SnapshotTime == "" {
+		return "", fmt.Errorf("error parsing volume id: %q, no snapshot time", id)
+	}
+	return v.SnapshotTime, nil
+}
+
+// getStorageAccount searches a secret map for account name/key, accepting either the
+// CSI standard field names or the legacy in-tree azurefile plugin field names. When
+// authType is kerberos, the account key is not required since mounts authenticate via
+// the node's Kerberos ticket cache rather than a shared key.
+func getStorageAccount(secrets map[string]string, authType string) (string, string, error) {
+	if secrets == nil {
+		return "", "", fmt.Errorf("unexpected: getStorageAccount secrets is nil")
+	}
+
+	var accountName, accountKey string
+	for k, v := range secrets {
+		switch strings.ToLower(k) {
+		case "accountname":
+			accountName = v
+		case "azurestorageaccountname":
+			accountName = v
+		case "accountkey":
+			accountKey = v
+		case "azurestorageaccountkey":
+			accountKey = v
+		}
+	}
+
+	if accountName == "" {
+		return "", "", fmt.Errorf("could not find accountname or azurestorageaccountname field secrets(%v)", secrets)
+	}
+	if accountKey == "" && !isKerberosAuth(authType) {
+		return "", "", fmt.Errorf("could not find accountkey or azurestorageaccountkey field in secrets(%v)", secrets)
+	}
+
+	return accountName, accountKey, nil
+}
+
+// getValidFileShareName generates a valid file share name from a volume name,
+// following the Azure Files share naming rules
+func getValidFileShareName(volumeName string) string {
+	fileShareName := strings.ToLower(volumeName)
+	if len(fileShareName) > 63 {
+		fileShareName = fileShareName[:63]
+	}
+	fileShareName = strings.Replace(fileShareName, "--", "-", -1)
+	if !checkShareNameBeginAndEnd(fileShareName) || len(fileShareName) < 3 {
+		fileShareName = generateVolumeName("pvc-file-dynamic", 64)
+	}
+	return fileShareName
+}
+
+// checkShareNameBeginAndEnd checks whether the file share name starts and ends
+// with a letter or number, as required by Azure Files
+func checkShareNameBeginAndEnd(fileShareName string) bool {
+	length := len(fileShareName)
+	if (('a' <= fileShareName[0] && fileShareName[0] <= 'z') ||
+		('0' <= fileShareName[0] && fileShareName[0] <= '9')) &&
+		(('a' <= fileShareName[length-1] && fileShareName[length-1] <= 'z') ||
+			('0' <= fileShareName[length-1] && fileShareName[length-1] <= '9')) {
+		return true
+	}
+
+	return false
+}
+
+// IsCorruptedDir checks whether the given directory is a corrupted mount point
+func IsCorruptedDir(dir string) bool {
+	_, pathErr := mount.PathExists(dir)
+	return mount.IsCorruptedMnt(pathErr)
+}
+
+// getFileSvcClient creates an azfile.ServiceURL from an account name/key pair
+func (d *Driver) getFileSvcClient(accountName, accountKey string) (*azfile.ServiceURL, error) {
+	credential, err := azfile.NewSharedKeyCredential(accountName, accountKey)
+	if err != nil {
+		return nil, fmt.Errorf("error creating azure client: %v", err)
+	}
+	pipeline := azfile.NewPipeline(credential, azfile.PipelineOptions{})
+	u, err := url.Parse(fmt.Sprintf("https://%s.file.%s", accountName, d.cloud.Environment.StorageEndpointSuffix))
+	if err != nil {
+		return nil, fmt.Errorf("error creating azure client: %v", err)
+	}
+	serviceURL := azfile.NewServiceURL(*u, pipeline)
+	return &serviceURL, nil
+}
+
+// getAccountKeyByIdentity fetches a storage account key through the managed-identity
+// path (AccountKeyGetter/accountKeyCache) rather than from a node-stage/controller
+// secret, for callers that need the raw key rather than a ready-made File service client.
+func (d *Driver) getAccountKeyByIdentity(ctx context.Context, subscriptionID, resourceGroup, accountName string) (string, error) {
+	if d.accountKeyGetter == nil {
+		d.accountKeyGetter = &cloudAccountKeyGetter{cloud: d.cloud}
+	}
+	if d.accountKeys == nil {
+		d.accountKeys = newAccountKeyCache(d.accountKeyGetter)
+	}
+	return d.accountKeys.get(ctx, subscriptionID, resourceGroup, accountName)
+}
+
+// getFileSvcClientByIdentity creates an azfile.ServiceURL the same way getFileSvcClient
+// does, but fetches the account key on demand through getAccountKeyByIdentity instead of
+// requiring the caller to already have it, so storage classes can omit nodeStageSecretRef
+// when UseManagedIdentity is set.
+func (d *Driver) getFileSvcClientByIdentity(ctx context.Context, subscriptionID, resourceGroup, accountName string) (*azfile.ServiceURL, error) {
+	accountKey, err := d.getAccountKeyByIdentity(ctx, subscriptionID, resourceGroup, accountName)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching account key for %s via managed identity: %v", accountName, err)
+	}
+
+	return d.getFileSvcClient(accountName, accountKey)
+}
+
+// getFileURL builds an azfile.FileURL for a given file share/disk combination
+func getFileURL(accountName, accountKey, storageEndpointSuffix, fileShareName, diskName string) (azfile.FileURL, error) {
+	if len(accountName) == 0 || len(accountKey) == 0 || len(fileShareName) == 0 || len(diskName) == 0 {
+		return azfile.FileURL{}, nil
+	}
+
+	credential, err := azfile.NewSharedKeyCredential(accountName, accountKey)
+	if err != nil {
+		return azfile.FileURL{}, fmt.Errorf("NewSharedKeyCredential(%s) failed with error: %v", accountName, err)
+	}
+	pipeline := azfile.NewPipeline(credential, azfile.PipelineOptions{})
+	u, err := url.Parse(fmt.Sprintf("https://%s.file.%s/%s/%s", accountName, storageEndpointSuffix, fileShareName, diskName))
+	if err != nil {
+		return azfile.FileURL{}, fmt.Errorf("parsing url failed with error: %v", err)
+	}
+
+	return azfile.NewFileURL(*u, pipeline), nil
+}
+
+// isNFSProtocol returns true when the storage class/volume context requests the
+// NFS 4.1 protocol rather than the default SMB/CIFS protocol
+func isNFSProtocol(protocol string) bool {
+	return strings.EqualFold(protocol, nfs)
+}
+
+// generateVolumeName generates a valid file share name from a prefix and the
+// driver's process ID; every call within one process returns the same name
+func generateVolumeName(prefix string, length int) string {
+	name := fmt.Sprintf("%s-%d", prefix, os.Getpid())
+	if len(name) > length {
+		name = name[:length]
+	}
+	return name
+}
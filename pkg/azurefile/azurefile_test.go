@@ -17,6 +17,7 @@ limitations under the License.
 package azurefile
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
 	"io/ioutil"
@@ -24,7 +25,9 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -94,6 +97,65 @@ func TestAppendDefaultMountOptions(t *testing.T) {
 	}
 }
 
+func TestAppendKerberosMountOptions(t *testing.T) {
+	tests := []struct {
+		options   []string
+		cruid     string
+		serverino bool
+		noperm    bool
+		expected  []string
+	}{
+		{
+			options:   []string{},
+			cruid:     "1000",
+			serverino: false,
+			noperm:    false,
+			expected: []string{
+				fmt.Sprintf("%s=%s", fileMode, defaultFileMode),
+				fmt.Sprintf("%s=%s", dirMode, defaultDirMode),
+				fmt.Sprintf("%s=%s", vers, defaultVers),
+				fmt.Sprintf("%s=%s", secOption, krb5iValue),
+				fmt.Sprintf("%s=%s", cruidOption, "1000"),
+			},
+		},
+		{
+			options:   []string{},
+			cruid:     "1000",
+			serverino: true,
+			noperm:    true,
+			expected: []string{
+				fmt.Sprintf("%s=%s", fileMode, defaultFileMode),
+				fmt.Sprintf("%s=%s", dirMode, defaultDirMode),
+				fmt.Sprintf("%s=%s", vers, defaultVers),
+				fmt.Sprintf("%s=%s", secOption, krb5iValue),
+				fmt.Sprintf("%s=%s", cruidOption, "1000"),
+				serverinoOption,
+				nopermOption,
+			},
+		},
+		{
+			options:   []string{fmt.Sprintf("%s=%s", secOption, "krb5")},
+			cruid:     "1000",
+			serverino: false,
+			noperm:    false,
+			expected: []string{
+				fmt.Sprintf("%s=%s", secOption, "krb5"),
+				fmt.Sprintf("%s=%s", fileMode, defaultFileMode),
+				fmt.Sprintf("%s=%s", dirMode, defaultDirMode),
+				fmt.Sprintf("%s=%s", vers, defaultVers),
+				fmt.Sprintf("%s=%s", cruidOption, "1000"),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		result := appendKerberosMountOptions(test.options, test.cruid, test.serverino, test.noperm)
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("input: %q, appendKerberosMountOptions result: %q, expected: %q", test.options, result, test.expected)
+		}
+	}
+}
+
 func TestGetFileShareInfo(t *testing.T) {
 	tests := []struct {
 		id                string
@@ -146,7 +208,7 @@ func TestGetFileShareInfo(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		resourceGroupName, accountName, fileShareName, diskName, expectedError := getFileShareInfo(test.id)
+		resourceGroupName, accountName, fileShareName, diskName, _, expectedError := getFileShareInfo(test.id)
 		if resourceGroupName != test.resourceGroupName {
 			t.Errorf("getFileShareInfo(%q) returned with: %q, expected: %q", test.id, resourceGroupName, test.resourceGroupName)
 		}
@@ -186,8 +248,14 @@ func TestGetStorageAccount(t *testing.T) {
 		"azurestorageaccountkey":  "testkey",
 	}
 
+	emptyAccountKeyKerberosMap := map[string]string{
+		"accountname": "testaccount",
+		"accountkey":  "",
+	}
+
 	tests := []struct {
 		options   map[string]string
+		authType  string
 		expected1 string
 		expected2 string
 		expected3 error
@@ -249,17 +317,24 @@ func TestGetStorageAccount(t *testing.T) {
 			expected2: "",
 			expected3: fmt.Errorf("unexpected: getStorageAccount secrets is nil"),
 		},
+		{
+			// with Kerberos auth, an empty account key is valid: the mount
+			// authenticates via the node's ticket cache, not a shared key
+			options:   emptyAccountKeyKerberosMap,
+			authType:  authTypeKerberos,
+			expected1: "testaccount",
+			expected2: "",
+			expected3: nil,
+		},
 	}
 
 	for _, test := range tests {
-		result1, result2, result3 := getStorageAccount(test.options)
+		result1, result2, result3 := getStorageAccount(test.options, test.authType)
 		if !reflect.DeepEqual(result1, test.expected1) || !reflect.DeepEqual(result2, test.expected2) {
 			t.Errorf("input: %q, getStorageAccount result1: %q, expected1: %q, result2: %q, expected2: %q, result3: %q, expected3: %q", test.options, result1, test.expected1, result2, test.expected2,
 				result3, test.expected3)
-		} else {
-			if result1 == "" || result2 == "" {
-				assert.Error(t, result3)
-			}
+		} else if test.authType != authTypeKerberos && (result1 == "" || result2 == "") {
+			assert.Error(t, result3)
 		}
 	}
 }
@@ -473,6 +548,81 @@ func TestGetFileSvcClient(t *testing.T) {
 	}
 }
 
+// fakeAccountKeyGetter is an AccountKeyGetter test fake: it returns a fixed key/error
+// per (subscription, resource group, account) and records how many times it was called.
+type fakeAccountKeyGetter struct {
+	keys  map[string]string
+	err   error
+	calls int
+}
+
+func (g *fakeAccountKeyGetter) GetAccountKey(ctx context.Context, subscriptionID, resourceGroup, accountName string) (string, error) {
+	g.calls++
+	if g.err != nil {
+		return "", g.err
+	}
+	return g.keys[strings.Join([]string{subscriptionID, resourceGroup, accountName}, separator)], nil
+}
+
+func TestGetFileSvcClientByIdentity(t *testing.T) {
+	tests := []struct {
+		desc          string
+		getter        *fakeAccountKeyGetter
+		expectedError error
+	}{
+		{
+			desc: "key fetched via managed identity",
+			getter: &fakeAccountKeyGetter{
+				keys: map[string]string{
+					strings.Join([]string{"sub", "rg", "accname"}, separator): base64.StdEncoding.EncodeToString([]byte("acc_key")),
+				},
+			},
+		},
+		{
+			desc:          "management API error is propagated",
+			getter:        &fakeAccountKeyGetter{err: fmt.Errorf("management API unavailable")},
+			expectedError: fmt.Errorf("error fetching account key for accname via managed identity: management API unavailable"),
+		},
+	}
+
+	for _, test := range tests {
+		d := NewFakeDriver()
+		d.cloud = &azure.Cloud{}
+		d.cloud.Environment.StorageEndpointSuffix = "url"
+		d.accountKeyGetter = test.getter
+
+		_, err := d.getFileSvcClientByIdentity(context.Background(), "sub", "rg", "accname")
+		if test.expectedError == nil {
+			assert.NoError(t, err, test.desc)
+		} else {
+			assert.EqualError(t, err, test.expectedError.Error(), test.desc)
+		}
+	}
+}
+
+func TestAccountKeyCache(t *testing.T) {
+	getter := &fakeAccountKeyGetter{keys: map[string]string{
+		strings.Join([]string{"sub", "rg", "accname"}, separator): "acc_key",
+	}}
+	cache := newAccountKeyCache(getter)
+
+	key, err := cache.get(context.Background(), "sub", "rg", "accname")
+	assert.NoError(t, err)
+	assert.Equal(t, "acc_key", key)
+
+	// a second lookup within the TTL must be served from cache, not the getter
+	key, err = cache.get(context.Background(), "sub", "rg", "accname")
+	assert.NoError(t, err)
+	assert.Equal(t, "acc_key", key)
+	assert.Equal(t, 1, getter.calls)
+
+	// once the cached entry has expired, the next lookup must re-hit the getter
+	cache.now = func() time.Time { return time.Now().Add(2 * accountKeyTTL) }
+	_, err = cache.get(context.Background(), "sub", "rg", "accname")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, getter.calls)
+}
+
 func TestGetFileURL(t *testing.T) {
 	tests := []struct {
 		accountName           string
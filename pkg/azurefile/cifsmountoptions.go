@@ -0,0 +1,222 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// sealOption and cacheOption are additional CIFS mount option keys understood by
+// CIFSMountOptions but not by the legacy appendDefaultMountOptions.
+const (
+	sealOption       = "seal"
+	cacheOption      = "cache"
+	mfsymlinksOption = "mfsymlinks"
+)
+
+// validCIFSVers are the CIFS/SMB protocol versions the driver allows a storage
+// class or pod to request.
+var validCIFSVers = map[string]bool{
+	"2.1":   true,
+	"3.0":   true,
+	"3.1.1": true,
+}
+
+// validCIFSCache are the accepted values for the cache= mount option.
+var validCIFSCache = map[string]bool{
+	"none":   true,
+	"strict": true,
+	"loose":  true,
+}
+
+// validCIFSSec are the accepted values for the sec= mount option, used by
+// Kerberos/AAD-DS identity-based mounts.
+var validCIFSSec = map[string]bool{
+	"krb5":     true,
+	krb5iValue: true,
+	"krb5p":    true,
+}
+
+// CIFSMountOptions is a typed, validated representation of the CIFS mount options
+// the driver supports, modeled on the approach Docker's volume mount parser uses
+// for tmpfs options (ConvertTmpfsOptions): parse into a struct up front, reject
+// anything invalid, then render back to canonical options rather than passing
+// raw, unchecked key=value tokens all the way down to the mount syscall.
+type CIFSMountOptions struct {
+	FileMode   os.FileMode
+	DirMode    os.FileMode
+	Vers       string
+	Nconnect   int
+	Seal       bool
+	Cache      string
+	Serverino  bool
+	Mfsymlinks bool
+	Sec        string
+	CRUID      string
+	Noperm     bool
+}
+
+// ParseMountOptions parses raw key=value CIFS mount options into a validated
+// CIFSMountOptions, filling in defaults for file_mode/dir_mode/vers when absent.
+// It rejects unknown keys, duplicate keys and out-of-range values.
+func ParseMountOptions(options []string) (CIFSMountOptions, error) {
+	result := CIFSMountOptions{
+		FileMode: parseFileModeDefault(defaultFileMode),
+		DirMode:  parseFileModeDefault(defaultDirMode),
+		Vers:     defaultVers,
+	}
+
+	seen := map[string]bool{}
+	for _, option := range options {
+		key, value, hasValue := splitMountOption(option)
+		if key == "" {
+			return CIFSMountOptions{}, fmt.Errorf("invalid mount option: %q", option)
+		}
+		if seen[key] {
+			return CIFSMountOptions{}, fmt.Errorf("duplicate mount option: %q", key)
+		}
+		seen[key] = true
+
+		switch key {
+		case fileMode:
+			mode, err := parseFileMode(value)
+			if err != nil {
+				return CIFSMountOptions{}, fmt.Errorf("invalid %s: %v", fileMode, err)
+			}
+			result.FileMode = mode
+		case dirMode:
+			mode, err := parseFileMode(value)
+			if err != nil {
+				return CIFSMountOptions{}, fmt.Errorf("invalid %s: %v", dirMode, err)
+			}
+			result.DirMode = mode
+		case vers:
+			if !validCIFSVers[value] {
+				return CIFSMountOptions{}, fmt.Errorf("invalid %s: %q, supported values are 2.1, 3.0, 3.1.1", vers, value)
+			}
+			result.Vers = value
+		case nconnect:
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return CIFSMountOptions{}, fmt.Errorf("invalid %s: %q, must be a positive integer", nconnect, value)
+			}
+			result.Nconnect = n
+		case sealOption:
+			result.Seal = true
+		case cacheOption:
+			if !validCIFSCache[value] {
+				return CIFSMountOptions{}, fmt.Errorf("invalid %s: %q, supported values are none, strict, loose", cacheOption, value)
+			}
+			result.Cache = value
+		case serverinoOption:
+			result.Serverino = true
+		case mfsymlinksOption:
+			result.Mfsymlinks = true
+		case secOption:
+			if !validCIFSSec[value] {
+				return CIFSMountOptions{}, fmt.Errorf("invalid %s: %q, supported values are krb5, krb5i, krb5p", secOption, value)
+			}
+			result.Sec = value
+		case cruidOption:
+			if value == "" {
+				return CIFSMountOptions{}, fmt.Errorf("invalid %s: value must not be empty", cruidOption)
+			}
+			result.CRUID = value
+		case nopermOption:
+			result.Noperm = true
+		default:
+			if hasValue {
+				return CIFSMountOptions{}, fmt.Errorf("unknown mount option: %q=%q", key, value)
+			}
+			return CIFSMountOptions{}, fmt.Errorf("unknown mount option: %q", key)
+		}
+	}
+
+	return result, nil
+}
+
+// Render emits the canonical, defaulted mount options for these CIFSMountOptions.
+func (o CIFSMountOptions) Render() []string {
+	options := []string{
+		fmt.Sprintf("%s=%04o", fileMode, o.FileMode),
+		fmt.Sprintf("%s=%04o", dirMode, o.DirMode),
+		fmt.Sprintf("%s=%s", vers, o.Vers),
+	}
+	if o.Nconnect > 0 {
+		options = append(options, fmt.Sprintf("%s=%d", nconnect, o.Nconnect))
+	}
+	if o.Seal {
+		options = append(options, sealOption)
+	}
+	if o.Cache != "" {
+		options = append(options, fmt.Sprintf("%s=%s", cacheOption, o.Cache))
+	}
+	if o.Serverino {
+		options = append(options, serverinoOption)
+	}
+	if o.Mfsymlinks {
+		options = append(options, mfsymlinksOption)
+	}
+	if o.Sec != "" {
+		options = append(options, fmt.Sprintf("%s=%s", secOption, o.Sec))
+	}
+	if o.CRUID != "" {
+		options = append(options, fmt.Sprintf("%s=%s", cruidOption, o.CRUID))
+	}
+	if o.Noperm {
+		options = append(options, nopermOption)
+	}
+	return options
+}
+
+func splitMountOption(option string) (key, value string, hasValue bool) {
+	if option == "" {
+		return "", "", false
+	}
+	parts := strings.SplitN(option, "=", 2)
+	if len(parts) == 2 {
+		return parts[0], parts[1], true
+	}
+	return parts[0], "", false
+}
+
+func parseFileMode(value string) (os.FileMode, error) {
+	if value == "" {
+		return 0, fmt.Errorf("mode must not be empty")
+	}
+	parsed, err := strconv.ParseUint(value, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a valid octal file mode", value)
+	}
+	if parsed > uint64(os.ModePerm) {
+		return 0, fmt.Errorf("%q is out of range, must be at most 0777", value)
+	}
+	return os.FileMode(parsed), nil
+}
+
+// parseFileModeDefault parses one of the package's own default mode constants;
+// a parse failure here would be a programming error, not user input.
+func parseFileModeDefault(value string) os.FileMode {
+	mode, err := parseFileMode(value)
+	if err != nil {
+		panic(fmt.Sprintf("invalid default file mode %q: %v", value, err))
+	}
+	return mode
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMountOptions(t *testing.T) {
+	tests := []struct {
+		desc        string
+		options     []string
+		expected    CIFSMountOptions
+		expectError bool
+	}{
+		{
+			desc:    "defaults when nothing is specified",
+			options: []string{},
+			expected: CIFSMountOptions{
+				FileMode: 0777,
+				DirMode:  0777,
+				Vers:     defaultVers,
+			},
+		},
+		{
+			desc:    "explicit file_mode, dir_mode and vers",
+			options: []string{"file_mode=0755", "dir_mode=0700", "vers=3.1.1"},
+			expected: CIFSMountOptions{
+				FileMode: 0755,
+				DirMode:  0700,
+				Vers:     "3.1.1",
+			},
+		},
+		{
+			desc:    "seal and nconnect as first-class options",
+			options: []string{"seal", "nconnect=4"},
+			expected: CIFSMountOptions{
+				FileMode: 0777,
+				DirMode:  0777,
+				Vers:     defaultVers,
+				Seal:     true,
+				Nconnect: 4,
+			},
+		},
+		{
+			desc:    "serverino, mfsymlinks and cache",
+			options: []string{"serverino", "mfsymlinks", "cache=strict"},
+			expected: CIFSMountOptions{
+				FileMode:   0777,
+				DirMode:    0777,
+				Vers:       defaultVers,
+				Serverino:  true,
+				Mfsymlinks: true,
+				Cache:      "strict",
+			},
+		},
+		{
+			desc:        "out-of-range file_mode is rejected",
+			options:     []string{"file_mode=0999"},
+			expectError: true,
+		},
+		{
+			desc:        "duplicate vers is rejected",
+			options:     []string{"vers=3.0", "vers=2.1"},
+			expectError: true,
+		},
+		{
+			desc:        "stray empty string is rejected",
+			options:     []string{""},
+			expectError: true,
+		},
+		{
+			desc:        "unsupported vers is rejected",
+			options:     []string{"vers=1.0"},
+			expectError: true,
+		},
+		{
+			desc:        "unknown option is rejected",
+			options:     []string{"bogus=1"},
+			expectError: true,
+		},
+		{
+			desc:        "non-positive nconnect is rejected",
+			options:     []string{"nconnect=0"},
+			expectError: true,
+		},
+		{
+			desc:        "unsupported cache value is rejected",
+			options:     []string{"cache=bogus"},
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		result, err := ParseMountOptions(test.options)
+		if test.expectError {
+			assert.Error(t, err, test.desc)
+			continue
+		}
+		assert.NoError(t, err, test.desc)
+		assert.Equal(t, test.expected, result, test.desc)
+	}
+}
+
+func TestCIFSMountOptionsRender(t *testing.T) {
+	opts := CIFSMountOptions{
+		FileMode: os.FileMode(0755),
+		DirMode:  os.FileMode(0700),
+		Vers:     "3.1.1",
+		Nconnect: 4,
+		Seal:     true,
+		Cache:    "strict",
+	}
+
+	rendered := opts.Render()
+
+	// Render output must itself be accepted by ParseMountOptions
+	parsed, err := ParseMountOptions(rendered)
+	assert.NoError(t, err)
+	assert.Equal(t, opts, parsed)
+}
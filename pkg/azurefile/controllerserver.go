@@ -0,0 +1,572 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2019-06-01/storage"
+	"github.com/Azure/azure-storage-file-go/azfile"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+)
+
+// fileCopySASValidity is how long the SAS token signing a source file's URL for a
+// server-side StartCopy remains valid. Copies are started, not waited on, so this
+// only needs to outlive the time it takes the File service to begin reading the file.
+const fileCopySASValidity = 1 * time.Hour
+
+// snapshotTimeFormat is the layout Azure Files uses for share-snapshot timestamps
+const snapshotTimeFormat = "2006-01-02T15:04:05.0000000Z"
+
+// giB is the byte-to-GiB conversion factor Azure Files share quotas are expressed in.
+const giB = 1024 * 1024 * 1024
+
+const (
+	resourceGroupField  = "resourcegroup"
+	storageAccountField = "storageaccount"
+	locationField       = "location"
+	skuNameField        = "skuname"
+)
+
+// CreateVolume provisions an Azure file share and returns it as a CSI volume
+func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	name := req.GetName()
+	if len(name) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "CreateVolume name must be provided")
+	}
+	if req.GetVolumeCapabilities() == nil {
+		return nil, status.Error(codes.InvalidArgument, "CreateVolume volume capabilities must be provided")
+	}
+
+	capacityBytes := req.GetCapacityRange().GetRequiredBytes()
+	fileShareSize := int32((capacityBytes + giB - 1) / giB)
+	if fileShareSize < 1 {
+		fileShareSize = 1
+	}
+
+	var resourceGroup, account, protocol, authType string
+	parameters := req.GetParameters()
+	for k, v := range parameters {
+		switch strings.ToLower(k) {
+		case resourceGroupField:
+			resourceGroup = v
+		case storageAccountField:
+			account = v
+		case protocolField:
+			protocol = v
+		case authTypeField:
+			authType = v
+		}
+	}
+
+	if protocol == "" {
+		protocol = smb
+	}
+	if !isNFSProtocol(protocol) && !strings.EqualFold(protocol, smb) {
+		return nil, status.Errorf(codes.InvalidArgument, "protocol(%s) is not supported, supported values are %s, %s", protocol, smb, nfs)
+	}
+	if authType == "" {
+		authType = authTypeAccountKey
+	}
+
+	fileShareName := getValidFileShareName(name)
+
+	// The share is created the same way for every protocol: createFileShare resolves
+	// an account key (from the secrets, or via managed identity) and creates the
+	// share through the File data plane; it does not set the share's enabled
+	// protocol. The protocol is only logged here and recorded in the volume ID and
+	// context, from which the node plugin picks the NFS or CIFS mount.
+	switch {
+	case isNFSProtocol(protocol):
+		klog.V(2).Infof("creating NFS file share %s on account %s in resource group %s", fileShareName, account, resourceGroup)
+	case isKerberosAuth(authType):
+		klog.V(2).Infof("creating SMB file share %s on account %s in resource group %s with Kerberos/AAD-DS identity access enabled", fileShareName, account, resourceGroup)
+	default:
+		klog.V(2).Infof("creating SMB file share %s on account %s in resource group %s", fileShareName, account, resourceGroup)
+	}
+
+	if err := d.createFileShare(ctx, req.GetSecrets(), resourceGroup, account, fileShareName, fileShareSize, protocol, authType); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to create file share %s: %v", fileShareName, err)
+	}
+
+	if req.GetVolumeContentSource() != nil {
+		if err := d.copyVolume(ctx, req, resourceGroup, account, fileShareName, protocol); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to copy volume content source to %s: %v", fileShareName, err)
+		}
+	}
+
+	volumeID := VolumeID{
+		SubscriptionID: d.cloud.SubscriptionID,
+		ResourceGroup:  resourceGroup,
+		Account:        account,
+		Share:          fileShareName,
+		Protocol:       protocol,
+		Version:        2,
+	}.String()
+
+	return &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:      volumeID,
+			CapacityBytes: capacityBytes,
+			VolumeContext: map[string]string{
+				protocolField: protocol,
+				authTypeField: authType,
+			},
+			ContentSource: req.GetVolumeContentSource(),
+		},
+	}, nil
+}
+
+// createFileShare provisions the backing Azure file share through the File
+// service data plane, using the same client resolution CreateSnapshot/DeleteSnapshot
+// rely on (managed identity when configured, otherwise the secret-based account key).
+// CreateVolume is expected to be retried by the CO on a transient failure, so an
+// already-existing share (from a prior attempt) is treated as success rather than an error.
+func (d *Driver) createFileShare(ctx context.Context, secrets map[string]string, resourceGroup, account, fileShareName string, sizeGiB int32, protocol, authType string) error {
+	if isKerberosAuth(authType) {
+		if err := d.ensureADIdentityConfigured(ctx, resourceGroup, account); err != nil {
+			return err
+		}
+	}
+
+	svcClient, err := d.getFileSvcClientForControllerOp(ctx, secrets, resourceGroup, account)
+	if err != nil {
+		return err
+	}
+
+	shareURL := svcClient.NewShareURL(fileShareName)
+	if _, err := shareURL.Create(ctx, azfile.Metadata{}, sizeGiB); err != nil {
+		if isShareAlreadyExistsError(err) {
+			klog.V(2).Infof("createFileShare: share %s on account %s already exists, proceeding", fileShareName, account)
+			return nil
+		}
+		return fmt.Errorf("failed to create share %s: %v", fileShareName, err)
+	}
+	return nil
+}
+
+// ensureADIdentityConfigured fails CreateVolume fast when the storage class asks
+// for Kerberos/AAD-DS identity access but the account hasn't actually been joined
+// to a domain yet. AzureFilesIdentityBasedAuthentication is a property of the
+// storage account, not of an individual share, so there is no per-share setting
+// a CSI CreateVolume call could set to turn Kerberos access on; domain-joining
+// the account requires AD/AADDS admin parameters (domain name, OU, DNS) that a
+// storage-class parameter set has no way to carry, so the driver only verifies
+// the precondition here instead of attempting to join the account itself.
+func (d *Driver) ensureADIdentityConfigured(ctx context.Context, resourceGroup, account string) error {
+	props, err := d.cloud.StorageAccountClient.GetProperties(ctx, resourceGroup, account, "")
+	if err != nil {
+		return fmt.Errorf("failed to get properties for account %s: %v", account, err)
+	}
+
+	identity := props.AzureFilesIdentityBasedAuthentication
+	if identity == nil || identity.DirectoryServiceOptions == storage.DirectoryServiceOptionsNone {
+		return fmt.Errorf("account %s is not configured for AD/AADDS identity-based authentication; join the account to a domain before creating a kerberos authtype share on it", account)
+	}
+	return nil
+}
+
+// copyVolume restores a volume from a snapshot VolumeContentSource; any other
+// source type, including a volume clone, is rejected with InvalidArgument
+func (d *Driver) copyVolume(ctx context.Context, req *csi.CreateVolumeRequest, resourceGroup, account, fileShareName, protocol string) error {
+	volumeSource := req.GetVolumeContentSource()
+	switch volumeSource.GetType().(type) {
+	case *csi.VolumeContentSource_Snapshot:
+		snapshot := volumeSource.GetSnapshot()
+		if snapshot == nil {
+			return status.Error(codes.InvalidArgument, "error retrieving snapshot from the volume content source")
+		}
+		return d.copyFileShareFromSnapshot(ctx, req.GetSecrets(), snapshot.GetSnapshotId(), resourceGroup, account, fileShareName)
+	default:
+		return status.Errorf(codes.InvalidArgument, "%v is not a proper volume source", volumeSource)
+	}
+}
+
+// DeleteVolume deletes an Azure file share
+func (d *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "DeleteVolume volume ID must be provided")
+	}
+
+	resourceGroup, account, fileShareName, _, _, err := getFileShareInfo(volumeID)
+	if err != nil {
+		// an invalid volume id should be treated as if the volume is already deleted
+		klog.Warningf("DeleteVolume: failed to parse volume id %s: %v", volumeID, err)
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+
+	if err := d.deleteFileShare(ctx, req.GetSecrets(), resourceGroup, account, fileShareName); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to delete file share %s: %v", fileShareName, err)
+	}
+
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+// deleteFileShare is the deprovisioning counterpart of createFileShare. A share
+// that is already gone (e.g. a retried DeleteVolume, or the account/share was
+// removed out of band) is treated as success so DeleteVolume stays idempotent,
+// mirroring DeleteSnapshot's handling of isShareNotFoundError.
+func (d *Driver) deleteFileShare(ctx context.Context, secrets map[string]string, resourceGroup, account, fileShareName string) error {
+	svcClient, err := d.getFileSvcClientForControllerOp(ctx, secrets, resourceGroup, account)
+	if err != nil {
+		return err
+	}
+
+	shareURL := svcClient.NewShareURL(fileShareName)
+	if _, err := shareURL.Delete(ctx, azfile.DeleteSnapshotsOptionInclude); err != nil {
+		if isShareNotFoundError(err) {
+			klog.V(2).Infof("deleteFileShare: share %s on account %s already deleted", fileShareName, account)
+			return nil
+		}
+		return fmt.Errorf("failed to delete share %s: %v", fileShareName, err)
+	}
+	return nil
+}
+
+// ControllerGetCapabilities is not implemented and always returns codes.Unimplemented
+func (d *Driver) ControllerGetCapabilities(ctx context.Context, req *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "")
+}
+
+// ValidateVolumeCapabilities checks whether the volume capabilities requested are supported
+func (d *Driver) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
+	if len(req.GetVolumeId()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "ValidateVolumeCapabilities volume ID must be provided")
+	}
+	if req.GetVolumeCapabilities() == nil {
+		return nil, status.Error(codes.InvalidArgument, "ValidateVolumeCapabilities volume capabilities must be provided")
+	}
+	return &csi.ValidateVolumeCapabilitiesResponse{
+		Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
+			VolumeContext:      req.GetVolumeContext(),
+			VolumeCapabilities: req.GetVolumeCapabilities(),
+			Parameters:         req.GetParameters(),
+		},
+	}, nil
+}
+
+func (d *Driver) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "")
+}
+
+func (d *Driver) ControllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "")
+}
+
+func (d *Driver) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "")
+}
+
+func (d *Driver) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "")
+}
+
+func (d *Driver) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+	return nil, status.Error(codes.Unimplemented, fmt.Sprintf("ControllerExpandVolume is not yet implemented"))
+}
+
+// CreateSnapshot creates a point-in-time share-snapshot of the source volume's file share
+func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+	sourceVolumeID := req.GetSourceVolumeId()
+	if len(sourceVolumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "CreateSnapshot source volume ID must be provided")
+	}
+	if len(req.GetName()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "CreateSnapshot snapshot name must be provided")
+	}
+
+	resourceGroup, account, fileShareName, diskName, protocol, err := getFileShareInfo(sourceVolumeID)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "CreateSnapshot: %v", err)
+	}
+
+	svcClient, err := d.getFileSvcClientForControllerOp(ctx, req.GetSecrets(), resourceGroup, account)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "CreateSnapshot: %v", err)
+	}
+
+	shareURL := svcClient.NewShareURL(fileShareName)
+	resp, err := shareURL.CreateSnapshot(ctx, azfile.Metadata{})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "CreateSnapshot: failed to snapshot share %s: %v", fileShareName, err)
+	}
+
+	snapshotTime := resp.Snapshot()
+	snapshotID := buildSnapshotID(d.cloud.SubscriptionID, resourceGroup, account, fileShareName, diskName, snapshotTime, protocol)
+
+	creationTime, err := parseSnapshotTime(snapshotTime)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "CreateSnapshot: failed to parse snapshot time %s: %v", snapshotTime, err)
+	}
+	creationTimestamp, err := ptypes.TimestampProto(creationTime)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "CreateSnapshot: %v", err)
+	}
+
+	return &csi.CreateSnapshotResponse{
+		Snapshot: &csi.Snapshot{
+			SnapshotId:     snapshotID,
+			SourceVolumeId: sourceVolumeID,
+			CreationTime:   creationTimestamp,
+			ReadyToUse:     true,
+		},
+	}, nil
+}
+
+// DeleteSnapshot deletes a share-snapshot previously created by CreateSnapshot
+func (d *Driver) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
+	snapshotID := req.GetSnapshotId()
+	if len(snapshotID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "DeleteSnapshot snapshot ID must be provided")
+	}
+
+	resourceGroup, account, fileShareName, _, _, err := getFileShareInfo(snapshotID)
+	if err != nil {
+		// a malformed/unknown snapshot id is treated as already deleted
+		klog.Warningf("DeleteSnapshot: failed to parse snapshot id %s: %v", snapshotID, err)
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+	snapshotTime, err := getSnapshot(snapshotID)
+	if err != nil {
+		klog.Warningf("DeleteSnapshot: failed to parse snapshot time from %s: %v", snapshotID, err)
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+
+	svcClient, err := d.getFileSvcClientForControllerOp(ctx, req.GetSecrets(), resourceGroup, account)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "DeleteSnapshot: %v", err)
+	}
+
+	shareURL := svcClient.NewShareURL(fileShareName).WithSnapshot(snapshotTime)
+	if _, err := shareURL.Delete(ctx, azfile.DeleteSnapshotsOptionNone); err != nil {
+		if isShareNotFoundError(err) {
+			// the snapshot is already gone: DeleteSnapshot must be idempotent
+			klog.V(2).Infof("DeleteSnapshot(%s) on account %s: snapshot already deleted", snapshotID, account)
+			return &csi.DeleteSnapshotResponse{}, nil
+		}
+		return nil, status.Errorf(codes.Internal, "DeleteSnapshot: failed to delete snapshot %s: %v", snapshotID, err)
+	}
+
+	return &csi.DeleteSnapshotResponse{}, nil
+}
+
+func (d *Driver) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "")
+}
+
+// getFileSvcClientForControllerOp returns a File service client for a controller RPC
+// (CreateVolume/DeleteVolume/CreateSnapshot/DeleteSnapshot), using the managed-identity
+// path when UseManagedIdentity is set so that storage classes need no
+// nodeStageSecretRef/ControllerCreateSecretRef, and falling back to the
+// secret-based account key otherwise.
+func (d *Driver) getFileSvcClientForControllerOp(ctx context.Context, secrets map[string]string, resourceGroup, account string) (*azfile.ServiceURL, error) {
+	if d.UseManagedIdentity {
+		return d.getFileSvcClientByIdentity(ctx, d.cloud.SubscriptionID, resourceGroup, account)
+	}
+
+	accountName, accountKey, err := getStorageAccount(secrets, authTypeAccountKey)
+	if err != nil {
+		return nil, err
+	}
+	return d.getFileSvcClient(accountName, accountKey)
+}
+
+// buildSnapshotID renders a v2 structured VolumeID carrying the resource group,
+// account, share, disk, snapshot time and source protocol, in the same format
+// getFileShareInfo/getSnapshot parse back.
+func buildSnapshotID(subscriptionID, resourceGroup, account, fileShareName, diskName, snapshotTime, protocol string) string {
+	return VolumeID{
+		SubscriptionID: subscriptionID,
+		ResourceGroup:  resourceGroup,
+		Account:        account,
+		Share:          fileShareName,
+		DiskName:       diskName,
+		SnapshotTime:   snapshotTime,
+		Protocol:       protocol,
+		Version:        2,
+	}.String()
+}
+
+func parseSnapshotTime(snapshotTime string) (time.Time, error) {
+	return time.Parse(snapshotTimeFormat, snapshotTime)
+}
+
+// isShareNotFoundError reports whether err represents a ShareNotFound / ShareSnapshotNotFound
+// response from the File service, used to make DeleteSnapshot and DeleteVolume idempotent
+func isShareNotFoundError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if stgErr, ok := err.(azfile.StorageError); ok {
+		switch stgErr.ServiceCode() {
+		case azfile.ServiceCodeShareNotFound, azfile.ServiceCodeShareSnapshotNotFound, azfile.ServiceCodeResourceNotFound:
+			return true
+		}
+	}
+	return false
+}
+
+// isShareAlreadyExistsError reports whether err represents a ShareAlreadyExists
+// response from the File service, used to make CreateVolume idempotent on retry.
+func isShareAlreadyExistsError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if stgErr, ok := err.(azfile.StorageError); ok {
+		return stgErr.ServiceCode() == azfile.ServiceCodeShareAlreadyExists
+	}
+	return false
+}
+
+// copyFileShareFromSnapshot server-side copies every file from a source share-snapshot
+// into the newly provisioned destination share, supporting restores across storage accounts.
+func (d *Driver) copyFileShareFromSnapshot(ctx context.Context, secrets map[string]string, snapshotID, dstResourceGroup, dstAccount, dstFileShareName string) error {
+	srcResourceGroup, srcAccount, srcFileShareName, _, _, err := getFileShareInfo(snapshotID)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid snapshot id %q: %v", snapshotID, err)
+	}
+	srcSnapshotTime, err := getSnapshot(snapshotID)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid snapshot id %q: %v", snapshotID, err)
+	}
+
+	// the source account may belong to a different resource group than the destination
+	// account, which is why the full source account name travels in the snapshot ID
+	// rather than being assumed to equal the destination account. Both accounts are
+	// looked up in the driver's own subscription.
+	klog.V(2).Infof("copyFileShareFromSnapshot: restoring share %s (account %s, rg %s, snapshot %s) into %s (account %s, rg %s)",
+		srcFileShareName, srcAccount, srcResourceGroup, srcSnapshotTime, dstFileShareName, dstAccount, dstResourceGroup)
+
+	srcAccountKey, dstAccountKey, err := d.getCopyAccountKeys(ctx, secrets, srcResourceGroup, srcAccount, dstResourceGroup, dstAccount)
+	if err != nil {
+		return status.Errorf(codes.Internal, "copyFileShareFromSnapshot: failed to resolve account keys: %v", err)
+	}
+
+	srcCredential, err := azfile.NewSharedKeyCredential(srcAccount, srcAccountKey)
+	if err != nil {
+		return status.Errorf(codes.Internal, "copyFileShareFromSnapshot: %v", err)
+	}
+	dstSvcClient, err := d.getFileSvcClient(dstAccount, dstAccountKey)
+	if err != nil {
+		return status.Errorf(codes.Internal, "copyFileShareFromSnapshot: %v", err)
+	}
+
+	srcHostURL, err := url.Parse(fmt.Sprintf("https://%s.file.%s", srcAccount, d.cloud.Environment.StorageEndpointSuffix))
+	if err != nil {
+		return status.Errorf(codes.Internal, "copyFileShareFromSnapshot: %v", err)
+	}
+	srcSvcURL := azfile.NewServiceURL(*srcHostURL, azfile.NewPipeline(srcCredential, azfile.PipelineOptions{}))
+	srcRootDirURL := srcSvcURL.NewShareURL(srcFileShareName).WithSnapshot(srcSnapshotTime).NewRootDirectoryURL()
+	dstRootDirURL := dstSvcClient.NewShareURL(dstFileShareName).NewRootDirectoryURL()
+
+	if err := d.startShareCopy(ctx, srcCredential, srcRootDirURL, dstRootDirURL, "/"); err != nil {
+		return status.Errorf(codes.Internal, "copyFileShareFromSnapshot: %v", err)
+	}
+	return nil
+}
+
+// getCopyAccountKeys resolves the account keys needed to authenticate against both
+// the source and destination storage accounts of a share-snapshot restore. With
+// managed identity each account's key is fetched independently via AAD; otherwise
+// both ends are authenticated with the single key supplied in secrets, so a
+// cross-account restore against accounts with different keys requires
+// UseManagedIdentity.
+func (d *Driver) getCopyAccountKeys(ctx context.Context, secrets map[string]string, srcResourceGroup, srcAccount, dstResourceGroup, dstAccount string) (string, string, error) {
+	if d.UseManagedIdentity {
+		srcAccountKey, err := d.getAccountKeyByIdentity(ctx, d.cloud.SubscriptionID, srcResourceGroup, srcAccount)
+		if err != nil {
+			return "", "", err
+		}
+		dstAccountKey, err := d.getAccountKeyByIdentity(ctx, d.cloud.SubscriptionID, dstResourceGroup, dstAccount)
+		if err != nil {
+			return "", "", err
+		}
+		return srcAccountKey, dstAccountKey, nil
+	}
+
+	_, accountKey, err := getStorageAccount(secrets, authTypeAccountKey)
+	if err != nil {
+		return "", "", err
+	}
+	return accountKey, accountKey, nil
+}
+
+// startShareCopy recursively walks the directories/files under srcDirURL, creating
+// the matching directory structure under dstDirURL and issuing a server-side
+// azfile.FileURL.StartCopy for each file. dirPath is only used for error messages.
+func (d *Driver) startShareCopy(ctx context.Context, srcCredential azfile.SharedKeyCredential, srcDirURL, dstDirURL azfile.DirectoryURL, dirPath string) error {
+	for marker := (azfile.Marker{}); marker.NotDone(); {
+		listResp, err := srcDirURL.ListFilesAndDirectoriesSegment(ctx, marker, azfile.ListFilesAndDirectoriesOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to list %s: %v", dirPath, err)
+		}
+		marker = listResp.NextMarker
+
+		for _, dirItem := range listResp.DirectoryItems {
+			childSrcDirURL := srcDirURL.NewDirectoryURL(dirItem.Name)
+			childDstDirURL := dstDirURL.NewDirectoryURL(dirItem.Name)
+			if _, err := childDstDirURL.Create(ctx, azfile.Metadata{}, azfile.SMBProperties{}); err != nil {
+				return fmt.Errorf("failed to create directory %s%s: %v", dirPath, dirItem.Name, err)
+			}
+			if err := d.startShareCopy(ctx, srcCredential, childSrcDirURL, childDstDirURL, dirPath+dirItem.Name+"/"); err != nil {
+				return err
+			}
+		}
+
+		for _, fileItem := range listResp.FileItems {
+			srcFileURL := srcDirURL.NewFileURL(fileItem.Name)
+			dstFileURL := dstDirURL.NewFileURL(fileItem.Name)
+
+			signedSrcURL, err := signFileURLForCopy(srcFileURL, srcCredential)
+			if err != nil {
+				return fmt.Errorf("failed to sign source URL for %s%s: %v", dirPath, fileItem.Name, err)
+			}
+			if _, err := dstFileURL.StartCopy(ctx, signedSrcURL, azfile.Metadata{}); err != nil {
+				return fmt.Errorf("failed to start copy of %s%s: %v", dirPath, fileItem.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// signFileURLForCopy returns fileURL with a read-only SAS token attached, so the
+// destination File service (which has no credential for the source account) can
+// authenticate the server-side read that StartCopy performs against it.
+func signFileURLForCopy(fileURL azfile.FileURL, credential azfile.SharedKeyCredential) (string, error) {
+	parts := azfile.NewFileURLParts(fileURL.URL())
+	sas, err := azfile.FileSASSignatureValues{
+		Protocol:    azfile.SASProtocolHTTPS,
+		ExpiryTime:  time.Now().Add(fileCopySASValidity),
+		ShareName:   parts.ShareName,
+		FilePath:    parts.DirectoryOrFilePath,
+		Permissions: azfile.FileSASPermissions{Read: true}.String(),
+	}.NewSASQueryParameters(&credential)
+	if err != nil {
+		return "", err
+	}
+	parts.SAS = sas
+	return parts.URL().String(), nil
+}
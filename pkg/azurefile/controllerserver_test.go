@@ -0,0 +1,254 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/legacy-cloud-providers/azure"
+)
+
+func TestBuildSnapshotID(t *testing.T) {
+	tests := []struct {
+		subscriptionID string
+		resourceGroup  string
+		account        string
+		fileShareName  string
+		diskName       string
+		snapshotTime   string
+		protocol       string
+	}{
+		{
+			subscriptionID: "sub1",
+			resourceGroup:  "rg",
+			account:        "f123",
+			fileShareName:  "csivolumename",
+			diskName:       "diskname",
+			snapshotTime:   "2019-08-22T07:17:53.0000000Z",
+			protocol:       smb,
+		},
+	}
+
+	for _, test := range tests {
+		result := buildSnapshotID(test.subscriptionID, test.resourceGroup, test.account, test.fileShareName, test.diskName, test.snapshotTime, test.protocol)
+		assert.True(t, strings.HasPrefix(result, volumeIDV2Prefix))
+
+		// the produced ID should round-trip through the parsers used by every RPC
+		resourceGroup, account, fileShareName, diskName, protocol, err := getFileShareInfo(result)
+		assert.NoError(t, err)
+		assert.Equal(t, test.resourceGroup, resourceGroup)
+		assert.Equal(t, test.account, account)
+		assert.Equal(t, test.fileShareName, fileShareName)
+		assert.Equal(t, test.diskName, diskName)
+		assert.Equal(t, test.protocol, protocol)
+
+		snapshotTime, err := getSnapshot(result)
+		assert.NoError(t, err)
+		assert.Equal(t, test.snapshotTime, snapshotTime)
+	}
+}
+
+func TestParseSnapshotTime(t *testing.T) {
+	tests := []struct {
+		snapshotTime string
+		expectError  bool
+	}{
+		{
+			snapshotTime: "2019-08-22T07:17:53.0000000Z",
+			expectError:  false,
+		},
+		{
+			snapshotTime: "not-a-time",
+			expectError:  true,
+		},
+		{
+			snapshotTime: "",
+			expectError:  true,
+		},
+	}
+
+	for _, test := range tests {
+		_, err := parseSnapshotTime(test.snapshotTime)
+		if test.expectError {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+}
+
+func TestIsShareNotFoundError(t *testing.T) {
+	assert.False(t, isShareNotFoundError(nil))
+	assert.False(t, isShareNotFoundError(fmt.Errorf("some other error")))
+}
+
+func TestCreateVolumeMissingArguments(t *testing.T) {
+	d := NewFakeDriver()
+	d.cloud = &azure.Cloud{}
+	d.cloud.Environment.StorageEndpointSuffix = "core.windows.net"
+
+	tests := []struct {
+		desc string
+		req  *csi.CreateVolumeRequest
+	}{
+		{
+			desc: "missing name",
+			req:  &csi.CreateVolumeRequest{VolumeCapabilities: []*csi.VolumeCapability{{}}},
+		},
+		{
+			desc: "missing volume capabilities",
+			req:  &csi.CreateVolumeRequest{Name: "vol1"},
+		},
+		{
+			desc: "unsupported protocol",
+			req: &csi.CreateVolumeRequest{
+				Name:               "vol1",
+				VolumeCapabilities: []*csi.VolumeCapability{{}},
+				Parameters:         map[string]string{protocolField: "ftp"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		_, err := d.CreateVolume(context.Background(), test.req)
+		assert.Error(t, err, test.desc)
+	}
+}
+
+func TestDeleteVolumeMissingArguments(t *testing.T) {
+	d := NewFakeDriver()
+	d.cloud = &azure.Cloud{}
+	d.cloud.Environment.StorageEndpointSuffix = "core.windows.net"
+
+	_, err := d.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{})
+	assert.Error(t, err)
+
+	// a malformed/unparseable volume id must be treated as already deleted
+	// rather than erroring out, so DeleteVolume stays idempotent on retry
+	resp, err := d.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "rg"})
+	assert.NoError(t, err)
+	assert.Equal(t, &csi.DeleteVolumeResponse{}, resp)
+}
+
+func TestCopyFileShareFromSnapshotMalformedID(t *testing.T) {
+	d := NewFakeDriver()
+	d.cloud = &azure.Cloud{}
+	d.cloud.Environment.StorageEndpointSuffix = "core.windows.net"
+
+	tests := []struct {
+		desc       string
+		snapshotID string
+	}{
+		{
+			desc:       "missing snapshot time",
+			snapshotID: "rg#account#share#disk",
+		},
+		{
+			desc:       "empty id",
+			snapshotID: "",
+		},
+	}
+
+	secrets := map[string]string{"accountname": "dstaccount", "accountkey": "ZHN0a2V5"}
+	for _, test := range tests {
+		err := d.copyFileShareFromSnapshot(context.Background(), secrets, test.snapshotID, "dstrg", "dstaccount", "dstshare")
+		assert.Error(t, err, test.desc)
+	}
+}
+
+func TestCopyFileShareFromSnapshotCrossAccountMissingSecrets(t *testing.T) {
+	d := NewFakeDriver()
+	d.cloud = &azure.Cloud{}
+	d.cloud.Environment.StorageEndpointSuffix = "core.windows.net"
+
+	// the source share lives under a different account/resource group than the
+	// volume being restored into, which is the cross-account restore scenario;
+	// without a ControllerCreateSecretRef (and without managed identity) there is
+	// no key to authenticate either end with, so this must fail rather than
+	// silently succeed with an empty destination share.
+	snapshotID := "srcrg#srcaccount#srcshare#diskname#2019-08-22T07:17:53.0000000Z"
+
+	err := d.copyFileShareFromSnapshot(context.Background(), nil, snapshotID, "dstrg", "dstaccount", "dstshare")
+	assert.Error(t, err)
+}
+
+func TestCreateSnapshotMissingArguments(t *testing.T) {
+	d := NewFakeDriver()
+	d.cloud = &azure.Cloud{}
+	d.cloud.Environment.StorageEndpointSuffix = "core.windows.net"
+
+	tests := []struct {
+		desc string
+		req  *csi.CreateSnapshotRequest
+	}{
+		{
+			desc: "missing source volume id",
+			req:  &csi.CreateSnapshotRequest{Name: "snap1"},
+		},
+		{
+			desc: "missing name",
+			req:  &csi.CreateSnapshotRequest{SourceVolumeId: "rg#account#share"},
+		},
+		{
+			desc: "malformed source volume id",
+			req:  &csi.CreateSnapshotRequest{SourceVolumeId: "rg", Name: "snap1"},
+		},
+	}
+
+	for _, test := range tests {
+		_, err := d.CreateSnapshot(context.Background(), test.req)
+		assert.Error(t, err, test.desc)
+	}
+}
+
+func TestDeleteSnapshot(t *testing.T) {
+	d := NewFakeDriver()
+	d.cloud = &azure.Cloud{}
+	d.cloud.Environment.StorageEndpointSuffix = "core.windows.net"
+
+	tests := []struct {
+		desc string
+		req  *csi.DeleteSnapshotRequest
+	}{
+		{
+			desc: "missing snapshot id",
+			req:  &csi.DeleteSnapshotRequest{},
+		},
+	}
+
+	for _, test := range tests {
+		_, err := d.DeleteSnapshot(context.Background(), test.req)
+		assert.Error(t, err, test.desc)
+	}
+
+	// a malformed/unparseable snapshot id must be treated as already deleted
+	// rather than erroring out, so DeleteSnapshot stays idempotent on retry
+	resp, err := d.DeleteSnapshot(context.Background(), &csi.DeleteSnapshotRequest{SnapshotId: "rg"})
+	assert.NoError(t, err)
+	assert.Equal(t, &csi.DeleteSnapshotResponse{}, resp)
+
+	// a snapshot id that parses but has no snapshot time component is likewise
+	// not a real snapshot handle and must be treated as already deleted
+	resp, err = d.DeleteSnapshot(context.Background(), &csi.DeleteSnapshotRequest{SnapshotId: "rg#account#share#disk"})
+	assert.NoError(t, err)
+	assert.Equal(t, &csi.DeleteSnapshotResponse{}, resp)
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+)
+
+// NodeStageVolume mounts the file share to a staging path on the node. For the
+// NFS protocol this mounts directly against the share's NFS endpoint with no
+// account key involved; for SMB/CIFS it mounts with the `cifs` filesystem type,
+// authenticating via the node's Kerberos ticket cache when authType is kerberos,
+// otherwise with an account key fetched via managed identity when
+// UseManagedIdentity is set, or read from the node-stage secret.
+func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "NodeStageVolume volume ID must be provided")
+	}
+
+	targetPath := req.GetStagingTargetPath()
+	if len(targetPath) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "NodeStageVolume staging target not provided")
+	}
+
+	volumeCapability := req.GetVolumeCapability()
+	if volumeCapability == nil {
+		return nil, status.Error(codes.InvalidArgument, "NodeStageVolume volume capability not provided")
+	}
+
+	resourceGroup, account, fileShareName, _, idProtocol, err := getFileShareInfo(volumeID)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// VolumeContext is the primary source of the protocol, but a v2 volume ID
+	// carries it too; fall back to that before defaulting to SMB so a caller
+	// that only has the volume ID (no context) still mounts the right family.
+	protocol := req.GetVolumeContext()[protocolField]
+	if protocol == "" {
+		protocol = idProtocol
+	}
+	if protocol == "" {
+		protocol = smb
+	}
+	authType := req.GetVolumeContext()[authTypeField]
+	if authType == "" {
+		authType = authTypeAccountKey
+	}
+
+	mountFlags := req.GetVolumeCapability().GetMount().GetMountFlags()
+
+	source := fmt.Sprintf("//%s.file.%s/%s", account, d.cloud.Environment.StorageEndpointSuffix, fileShareName)
+
+	var mountOptions []string
+	var sensitiveMountOptions []string
+	switch {
+	case isNFSProtocol(protocol):
+		// NFS 4.1 shares need no account key: the share is reachable directly over
+		// the storage account's NFS endpoint, authorized by VNet/private-endpoint access.
+		klog.V(2).Infof("NodeStageVolume: mounting NFS share %s on account %s in resource group %s, skipping secret retrieval", fileShareName, account, resourceGroup)
+		mountOptions = appendDefaultNFSMountOptions(mountFlags)
+		source = fmt.Sprintf("%s.file.%s:/%s/%s", account, d.cloud.Environment.StorageEndpointSuffix, account, fileShareName)
+	case isKerberosAuth(authType):
+		// Kerberos/AAD-DS identity access needs no account key either: the mount
+		// authenticates via the ticket already sitting in the node's kinit cache.
+		klog.V(2).Infof("NodeStageVolume: mounting SMB share %s on account %s in resource group %s with Kerberos auth, skipping secret retrieval", fileShareName, account, resourceGroup)
+		podUID := req.GetVolumeContext()["csi.storage.k8s.io/pod.uid"]
+		serverino := req.GetVolumeContext()[serverinoOption] == "true"
+		noperm := req.GetVolumeContext()[nopermOption] == "true"
+		// route through the same ParseMountOptions validation the default/account-key
+		// branch uses below, so a Kerberos mount can't carry invalid options (a bad
+		// file_mode, a duplicate vers=, ...) that the SMB path would otherwise reject.
+		kerberosFlags := appendKerberosMountOptions(mountFlags, podUID, serverino, noperm)
+		cifsOptions, err := ParseMountOptions(kerberosFlags)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		mountOptions = cifsOptions.Render()
+	default:
+		var accountName, accountKey string
+		if d.UseManagedIdentity {
+			// the node plugin fetches the key itself via AAD, so the storage class
+			// can omit nodeStageSecretRef entirely
+			klog.V(2).Infof("NodeStageVolume: mounting SMB share %s on account %s in resource group %s using managed identity, skipping secret retrieval", fileShareName, account, resourceGroup)
+			accountName = account
+			accountKey, err = d.getAccountKeyByIdentity(ctx, d.cloud.SubscriptionID, resourceGroup, account)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "NodeStageVolume: %v", err)
+			}
+		} else {
+			accountName, accountKey, err = getStorageAccount(req.GetSecrets(), authType)
+			if err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+			if accountName != account {
+				klog.V(2).Infof("NodeStageVolume: account name in volume ID (%s) differs from secret (%s), using secret value", account, accountName)
+			}
+		}
+		// the account key authenticates the CIFS mount itself, so it must never
+		// land in mountOptions (which are logged); it's passed as a sensitive
+		// mount option instead, the same way a pre-shared secret would be.
+		sensitiveMountOptions = []string{fmt.Sprintf("username=%s", accountName), fmt.Sprintf("password=%s", accountKey)}
+
+		// seal (SMB3 encryption) and nconnect (multichannel) are exposed as
+		// first-class storage-class/volume-context parameters rather than
+		// free-form mount flags, so fold them in before validation.
+		cifsFlags := mountFlags
+		if seal := req.GetVolumeContext()[sealOption]; seal == "true" {
+			cifsFlags = append(cifsFlags, sealOption)
+		}
+		if nc := req.GetVolumeContext()[nconnect]; nc != "" {
+			cifsFlags = append(cifsFlags, fmt.Sprintf("%s=%s", nconnect, nc))
+		}
+
+		cifsOptions, err := ParseMountOptions(cifsFlags)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		mountOptions = cifsOptions.Render()
+		source = fmt.Sprintf("//%s.file.%s/%s", accountName, d.cloud.Environment.StorageEndpointSuffix, fileShareName)
+	}
+
+	if err := os.MkdirAll(targetPath, 0750); err != nil {
+		return nil, status.Errorf(codes.Internal, "could not create target dir %s: %v", targetPath, err)
+	}
+
+	fsType := "cifs"
+	if isNFSProtocol(protocol) {
+		fsType = "nfs"
+	}
+
+	if err := d.mounter.MountSensitive(source, targetPath, fsType, mountOptions, sensitiveMountOptions); err != nil {
+		return nil, status.Errorf(codes.Internal, "could not mount %s at %s: %v", source, targetPath, err)
+	}
+
+	return &csi.NodeStageVolumeResponse{}, nil
+}
+
+func (d *Driver) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
+	targetPath := req.GetStagingTargetPath()
+	if len(targetPath) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "NodeUnstageVolume staging target not provided")
+	}
+	if err := d.mounter.Unmount(targetPath); err != nil {
+		return nil, status.Errorf(codes.Internal, "could not unmount %s: %v", targetPath, err)
+	}
+	return &csi.NodeUnstageVolumeResponse{}, nil
+}
+
+func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	targetPath := req.GetTargetPath()
+	if len(targetPath) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "NodePublishVolume target path not provided")
+	}
+	stagingPath := req.GetStagingTargetPath()
+	if len(stagingPath) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "NodePublishVolume staging target not provided")
+	}
+
+	mountOptions := []string{"bind"}
+	if req.GetReadonly() {
+		mountOptions = append(mountOptions, "ro")
+	}
+
+	if err := os.MkdirAll(targetPath, 0750); err != nil {
+		return nil, status.Errorf(codes.Internal, "could not create target dir %s: %v", targetPath, err)
+	}
+
+	if err := d.mounter.Mount(stagingPath, targetPath, "", mountOptions); err != nil {
+		return nil, status.Errorf(codes.Internal, "could not bind mount %s at %s: %v", stagingPath, targetPath, err)
+	}
+
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+func (d *Driver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
+	targetPath := req.GetTargetPath()
+	if len(targetPath) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "NodeUnpublishVolume target path not provided")
+	}
+	if err := d.mounter.Unmount(targetPath); err != nil {
+		return nil, status.Errorf(codes.Internal, "could not unmount %s: %v", targetPath, err)
+	}
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
+
+func (d *Driver) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
+	return &csi.NodeGetCapabilitiesResponse{}, nil
+}
+
+func (d *Driver) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
+	return &csi.NodeGetInfoResponse{
+		NodeId: d.NodeID,
+	}, nil
+}
+
+func (d *Driver) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "")
+}
+
+func (d *Driver) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "")
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/legacy-cloud-providers/azure"
+)
+
+func TestNodeStageVolumeMissingArguments(t *testing.T) {
+	d := NewFakeDriver()
+	d.cloud = &azure.Cloud{}
+	d.cloud.Environment.StorageEndpointSuffix = "core.windows.net"
+
+	volumeCapability := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+	}
+
+	tests := []struct {
+		desc string
+		req  *csi.NodeStageVolumeRequest
+	}{
+		{
+			desc: "missing volume id",
+			req:  &csi.NodeStageVolumeRequest{StagingTargetPath: "/mnt/test", VolumeCapability: volumeCapability},
+		},
+		{
+			desc: "missing staging target path",
+			req:  &csi.NodeStageVolumeRequest{VolumeId: "rg#account#share", VolumeCapability: volumeCapability},
+		},
+		{
+			desc: "missing volume capability",
+			req:  &csi.NodeStageVolumeRequest{VolumeId: "rg#account#share", StagingTargetPath: "/mnt/test"},
+		},
+		{
+			desc: "malformed volume id",
+			req:  &csi.NodeStageVolumeRequest{VolumeId: "rg", StagingTargetPath: "/mnt/test", VolumeCapability: volumeCapability},
+		},
+	}
+
+	for _, test := range tests {
+		_, err := d.NodeStageVolume(context.Background(), test.req)
+		assert.Error(t, err, test.desc)
+	}
+}
+
+func TestNodeStageVolumeDefaultBranchMissingSecrets(t *testing.T) {
+	d := NewFakeDriver()
+	d.cloud = &azure.Cloud{}
+	d.cloud.Environment.StorageEndpointSuffix = "core.windows.net"
+
+	// the default (account-key) branch has no secrets to resolve an account key
+	// from, and no managed identity configured either, so it must fail before
+	// ever reaching the mounter rather than attempting to mount with an empty key.
+	req := &csi.NodeStageVolumeRequest{
+		VolumeId:          "rg#account#share",
+		StagingTargetPath: "/mnt/test",
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		},
+	}
+
+	_, err := d.NodeStageVolume(context.Background(), req)
+	assert.Error(t, err)
+}
+
+func TestNodeStageVolumeKerberosBranchValidatesMountOptions(t *testing.T) {
+	d := NewFakeDriver()
+	d.cloud = &azure.Cloud{}
+	d.cloud.Environment.StorageEndpointSuffix = "core.windows.net"
+
+	// the Kerberos branch now routes through ParseMountOptions just like the
+	// account-key branch does, so an invalid file_mode must be rejected instead
+	// of silently reaching the mounter with a bad CIFS option.
+	req := &csi.NodeStageVolumeRequest{
+		VolumeId:          "rg#account#share",
+		StagingTargetPath: "/mnt/test",
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{
+				MountFlags: []string{"file_mode=badmode"},
+			}},
+		},
+		VolumeContext: map[string]string{
+			authTypeField: authTypeKerberos,
+		},
+	}
+
+	_, err := d.NodeStageVolume(context.Background(), req)
+	assert.Error(t, err)
+}
+
+func TestNodeStageVolumeManagedIdentityBranchValidatesMountOptions(t *testing.T) {
+	d := NewFakeDriver()
+	d.cloud = &azure.Cloud{}
+	d.cloud.Environment.StorageEndpointSuffix = "core.windows.net"
+	d.UseManagedIdentity = true
+	d.accountKeyGetter = &fakeAccountKeyGetter{keys: map[string]string{
+		strings.Join([]string{"", "rg", "account"}, separator): "ZHN0a2V5",
+	}}
+
+	// managed identity resolves the account key successfully, but an unknown
+	// mount option must still be rejected by ParseMountOptions before the
+	// (unreachable in this test) mounter is ever invoked.
+	req := &csi.NodeStageVolumeRequest{
+		VolumeId:          "rg#account#share",
+		StagingTargetPath: "/mnt/test",
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{
+				MountFlags: []string{"not_a_real_option"},
+			}},
+		},
+	}
+
+	_, err := d.NodeStageVolume(context.Background(), req)
+	assert.Error(t, err)
+}
+
+func TestNodeUnstageVolumeMissingArguments(t *testing.T) {
+	d := NewFakeDriver()
+
+	_, err := d.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{})
+	assert.Error(t, err)
+}
+
+func TestNodePublishVolumeMissingArguments(t *testing.T) {
+	d := NewFakeDriver()
+
+	tests := []struct {
+		desc string
+		req  *csi.NodePublishVolumeRequest
+	}{
+		{
+			desc: "missing target path",
+			req:  &csi.NodePublishVolumeRequest{StagingTargetPath: "/mnt/staging"},
+		},
+		{
+			desc: "missing staging target path",
+			req:  &csi.NodePublishVolumeRequest{TargetPath: "/mnt/target"},
+		},
+	}
+
+	for _, test := range tests {
+		_, err := d.NodePublishVolume(context.Background(), test.req)
+		assert.Error(t, err, test.desc)
+	}
+}
+
+func TestNodeUnpublishVolumeMissingArguments(t *testing.T) {
+	d := NewFakeDriver()
+
+	_, err := d.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// volumeIDV2Prefix marks a structured, versioned volume handle. Anything not
+// carrying this prefix is parsed as a legacy "#"-delimited handle instead.
+const volumeIDV2Prefix = "v2:"
+
+// VolumeID is the structured representation of a CSI volume/snapshot handle.
+// It replaces counting "#" separators in an opaque string, which breaks the
+// moment a resource group or share name legitimately contains "#" and cannot
+// carry new fields without silently shifting the position of existing ones.
+type VolumeID struct {
+	SubscriptionID string `json:"subscriptionID,omitempty"`
+	ResourceGroup  string `json:"resourceGroup"`
+	Account        string `json:"account"`
+	Share          string `json:"share"`
+	DiskName       string `json:"diskName,omitempty"`
+	SnapshotTime   string `json:"snapshotTime,omitempty"`
+	Protocol       string `json:"protocol,omitempty"`
+	// Version is 1 for the legacy "#"-delimited handle and 2 for the
+	// base64-encoded JSON handle emitted by String().
+	Version int `json:"-"`
+}
+
+// String renders the volume ID back into the wire format used as the CSI
+// VolumeId/SnapshotId. A v2 VolumeID is emitted as "v2:<base64-json>"; anything
+// else round-trips through the legacy rg#account#share[#disk[#snapshot]] format.
+func (v VolumeID) String() string {
+	if v.Version >= 2 {
+		data, err := json.Marshal(v)
+		if err != nil {
+			// VolumeID only holds strings, so Marshal cannot fail in practice
+			return ""
+		}
+		return volumeIDV2Prefix + base64.StdEncoding.EncodeToString(data)
+	}
+
+	segments := []string{v.ResourceGroup, v.Account, v.Share}
+	if v.DiskName != "" || v.SnapshotTime != "" {
+		segments = append(segments, v.DiskName)
+	}
+	if v.SnapshotTime != "" {
+		segments = append(segments, v.SnapshotTime)
+	}
+	return strings.Join(segments, separator)
+}
+
+// ParseVolumeID parses a CSI VolumeId/SnapshotId into a VolumeID, whether it is
+// a v2 structured handle or a legacy "#"-delimited one. Every RPC that needs to
+// inspect a volume/snapshot handle should go through this single entry point.
+func ParseVolumeID(id string) (VolumeID, error) {
+	if strings.HasPrefix(id, volumeIDV2Prefix) {
+		return parseV2VolumeID(id)
+	}
+	return parseLegacyVolumeID(id)
+}
+
+func parseV2VolumeID(id string) (VolumeID, error) {
+	encoded := strings.TrimPrefix(id, volumeIDV2Prefix)
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return VolumeID{}, fmt.Errorf("error parsing volume id: %q, invalid v2 handle: %v", id, err)
+	}
+	var v VolumeID
+	if err := json.Unmarshal(data, &v); err != nil {
+		return VolumeID{}, fmt.Errorf("error parsing volume id: %q, invalid v2 handle: %v", id, err)
+	}
+	v.Version = 2
+	return v, nil
+}
+
+func parseLegacyVolumeID(id string) (VolumeID, error) {
+	segments := strings.Split(id, separator)
+	if len(segments) < 3 {
+		return VolumeID{}, fmt.Errorf("error parsing volume id: %q, should at least contain two %s", id, separator)
+	}
+
+	v := VolumeID{
+		ResourceGroup: segments[0],
+		Account:       segments[1],
+		Share:         segments[2],
+		Version:       1,
+	}
+	if len(segments) > 3 {
+		v.DiskName = segments[3]
+	}
+	if len(segments) > 4 {
+		v.SnapshotTime = segments[4]
+	}
+	return v, nil
+}
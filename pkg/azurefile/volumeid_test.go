@@ -0,0 +1,186 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azurefile
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVolumeID(t *testing.T) {
+	tests := []struct {
+		desc          string
+		id            string
+		expected      VolumeID
+		expectedError error
+	}{
+		// legacy cases migrated from TestGetFileShareInfo
+		{
+			desc: "legacy id with disk name",
+			id:   "rg#f5713de20cde511e8ba4900#pvc-file-dynamic-17e43f84-f474-11e8-acd0-000d3a00df41#diskname.vhd",
+			expected: VolumeID{
+				ResourceGroup: "rg",
+				Account:       "f5713de20cde511e8ba4900",
+				Share:         "pvc-file-dynamic-17e43f84-f474-11e8-acd0-000d3a00df41",
+				DiskName:      "diskname.vhd",
+				Version:       1,
+			},
+		},
+		{
+			desc: "legacy id without disk name",
+			id:   "rg#f5713de20cde511e8ba4900#pvc-file-dynamic-17e43f84-f474-11e8-acd0-000d3a00df41",
+			expected: VolumeID{
+				ResourceGroup: "rg",
+				Account:       "f5713de20cde511e8ba4900",
+				Share:         "pvc-file-dynamic-17e43f84-f474-11e8-acd0-000d3a00df41",
+				Version:       1,
+			},
+		},
+		{
+			desc:          "too few segments",
+			id:            "rg#f5713de20cde511e8ba4900",
+			expectedError: fmt.Errorf("error parsing volume id: %q, should at least contain two %s", "rg#f5713de20cde511e8ba4900", separator),
+		},
+		{
+			desc:          "single segment",
+			id:            "rg",
+			expectedError: fmt.Errorf("error parsing volume id: %q, should at least contain two %s", "rg", separator),
+		},
+		{
+			desc:          "empty id",
+			id:            "",
+			expectedError: fmt.Errorf("error parsing volume id: %q, should at least contain two %s", "", separator),
+		},
+		// legacy cases migrated from TestGetSnapshot
+		{
+			desc: "legacy snapshot id",
+			id:   "rg#f123#csivolumename#diskname#2019-08-22T07:17:53.0000000Z",
+			expected: VolumeID{
+				ResourceGroup: "rg",
+				Account:       "f123",
+				Share:         "csivolumename",
+				DiskName:      "diskname",
+				SnapshotTime:  "2019-08-22T07:17:53.0000000Z",
+				Version:       1,
+			},
+		},
+		// new case: this is exactly the ambiguity a v2 handle exists to avoid - a
+		// legacy id cannot tell a literal "#" in the resource group apart from a
+		// field separator, so it silently shifts every later field by one position
+		{
+			desc: "legacy id misparses a literal # in an earlier field",
+			id:   "my#rg#f123#csivolumename#diskname",
+			expected: VolumeID{
+				ResourceGroup: "my",
+				Account:       "rg",
+				Share:         "f123",
+				DiskName:      "csivolumename",
+				SnapshotTime:  "diskname",
+				Version:       1,
+			},
+		},
+		// new cases: empty components
+		{
+			desc: "empty account and share",
+			id:   "rg##",
+			expected: VolumeID{
+				ResourceGroup: "rg",
+				Version:       1,
+			},
+		},
+		// new cases: v2 structured handle
+		{
+			desc: "v2 handle round trip",
+			id: VolumeID{
+				SubscriptionID: "sub",
+				ResourceGroup:  "rg#with#hash",
+				Account:        "account",
+				Share:          "share",
+				DiskName:       "disk",
+				Protocol:       nfs,
+				Version:        2,
+			}.String(),
+			expected: VolumeID{
+				SubscriptionID: "sub",
+				ResourceGroup:  "rg#with#hash",
+				Account:        "account",
+				Share:          "share",
+				DiskName:       "disk",
+				Protocol:       nfs,
+				Version:        2,
+			},
+		},
+		{
+			desc:          "malformed v2 handle",
+			id:            "v2:not-valid-base64!!",
+			expectedError: fmt.Errorf("invalid v2 handle"),
+		},
+	}
+
+	for _, test := range tests {
+		result, err := ParseVolumeID(test.id)
+		if test.expectedError != nil {
+			assert.Error(t, err, test.desc)
+			continue
+		}
+		assert.NoError(t, err, test.desc)
+		assert.Equal(t, test.expected, result, test.desc)
+	}
+}
+
+func TestVolumeIDString(t *testing.T) {
+	tests := []struct {
+		desc     string
+		v        VolumeID
+		expected string
+	}{
+		{
+			desc: "legacy volume id",
+			v: VolumeID{
+				ResourceGroup: "rg",
+				Account:       "account",
+				Share:         "share",
+				Version:       1,
+			},
+			expected: "rg#account#share",
+		},
+		{
+			desc: "legacy volume id with disk and snapshot",
+			v: VolumeID{
+				ResourceGroup: "rg",
+				Account:       "account",
+				Share:         "share",
+				DiskName:      "disk",
+				SnapshotTime:  "2019-08-22T07:17:53.0000000Z",
+				Version:       1,
+			},
+			expected: "rg#account#share#disk#2019-08-22T07:17:53.0000000Z",
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, test.v.String(), test.desc)
+	}
+
+	// a v2 handle must round trip through ParseVolumeID
+	v2 := VolumeID{ResourceGroup: "rg", Account: "account", Share: "share", Version: 2}
+	parsed, err := ParseVolumeID(v2.String())
+	assert.NoError(t, err)
+	assert.Equal(t, v2, parsed)
+}